@@ -15,26 +15,29 @@ package main
 
 import (
 	"fmt"
-	stdlog "log"
+	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/rehttp"
 	"github.com/alecthomas/kingpin/v2"
-	"github.com/go-kit/log"
-	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/promlog"
-	"github.com/prometheus/common/promlog/flag"
+	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
+	bolt "go.etcd.io/bbolt"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/monitoring/v3"
 	"google.golang.org/api/option"
@@ -85,12 +88,20 @@ var (
 		"stackdriver.retry-statuses", "The HTTP statuses that should trigger a retry.",
 	).Default("503").Ints()
 
+	stackdriverMaxQPS = kingpin.Flag(
+		"stackdriver.max-qps", "Max number of requests per second that should be made to the Stackdriver Monitoring API, shared across all configured projects.",
+	).Default("14").Float64()
+
 	// Monitoring collector flags
 
 	monitoringMetricsPrefixes = kingpin.Flag(
 		"monitoring.metrics-prefixes", "Google Stackdriver Monitoring Metric Type prefixes. Repeat this flag to scrape multiple prefixes.",
 	).Required().Strings()
 
+	monitoringMetricsPrefixesExclude = kingpin.Flag(
+		"monitoring.metrics-prefixes-exclude", "Google Stackdriver Monitoring Metric Type prefixes to exclude from the ones matched by --monitoring.metrics-prefixes. Repeat this flag to exclude multiple prefixes.",
+	).Strings()
+
 	monitoringMetricsInterval = kingpin.Flag(
 		"monitoring.metrics-interval", "Interval to request the Google Stackdriver Monitoring Metrics for. Only the most recent data point is used.",
 	).Default("5m").Duration()
@@ -131,6 +142,47 @@ var (
 	monitoringDescriptorCacheOnlyGoogle = kingpin.Flag(
 		"monitoring.descriptor-cache-only-google", "Only cache descriptors for *.googleapis.com metrics",
 	).Default("true").Bool()
+
+	monitoringAggregateDeltasStore = kingpin.Flag(
+		"monitoring.aggregate-deltas-store", "Store to use for the in-flight aggregated DELTA metric state. `bolt` persists to disk so aggregated counters/histograms survive a restart instead of resetting.",
+	).Default("memory").Enum("memory", "bolt")
+
+	monitoringAggregateDeltasStorePath = kingpin.Flag(
+		"monitoring.aggregate-deltas-store-path", "Path to the bbolt database file used when --monitoring.aggregate-deltas-store=bolt.",
+	).Default("/var/lib/stackdriver_exporter/deltas.db").String()
+
+	monitoringMaxConcurrentProjects = kingpin.Flag(
+		"monitoring.max-concurrent-projects", "Max number of projects to collect from concurrently. Set to 1 to collect serially.",
+	).Default("1").Int()
+
+	monitoringMaxConcurrentPrefixes = kingpin.Flag(
+		"monitoring.max-concurrent-prefixes", "Max number of metric prefixes to collect from concurrently, within a single project's collector.",
+	).Default("1").Int()
+
+	monitoringAggregationAlignmentPeriod = kingpin.Flag(
+		"monitoring.aggregation.alignment-period", "Alignment period to request via the Monitoring API's ListTimeSeries aggregation (e.g. 60s). Leave unset to let the API choose.",
+	).Duration()
+
+	monitoringAggregationPerSeriesAligner = kingpin.Flag(
+		"monitoring.aggregation.per-series-aligner", "Per-series aligner to request via the Monitoring API's ListTimeSeries aggregation (e.g. ALIGN_MEAN, ALIGN_RATE, ALIGN_DELTA).",
+	).String()
+
+	monitoringAggregationCrossSeriesReducer = kingpin.Flag(
+		"monitoring.aggregation.cross-series-reducer", "Cross-series reducer to request via the Monitoring API's ListTimeSeries aggregation (e.g. REDUCE_SUM, REDUCE_MEAN).",
+	).String()
+
+	monitoringAggregationGroupByFields = kingpin.Flag(
+		"monitoring.aggregation.group-by-fields", "Fields to group by in the Monitoring API's ListTimeSeries aggregation. Repeat this flag for multiple fields.",
+	).Strings()
+
+	monitoringAggregationPerPrefix = kingpin.Flag(
+		"monitoring.aggregation.per-prefix",
+		"Per-prefix aggregation override, overriding the global --monitoring.aggregation.* flags for metrics under that prefix. i.e: compute.googleapis.com/instance/disk:alignment-period=60s,per-series-aligner=ALIGN_RATE,cross-series-reducer=REDUCE_SUM,group-by-fields=resource.labels.instance_id",
+	).Strings()
+
+	monitoringNativeHistograms = kingpin.Flag(
+		"monitoring.native-histograms", "Emit DISTRIBUTION-valued metrics as Prometheus native histograms instead of classic histograms with explicit `le` buckets. Falls back to classic histograms for scrapers that don't negotiate native histogram support.",
+	).Default("false").Bool()
 )
 
 func init() {
@@ -148,6 +200,21 @@ func getDefaultGCPProject(ctx context.Context) (*[]string, error) {
 	return &[]string{credentials.ProjectID}, nil
 }
 
+// rateLimitedTransport caps the rate of outgoing requests to the Stackdriver Monitoring API, so
+// stackdriver_exporter stays within GCP's per-project ListTimeSeries/ListMetricDescriptors quotas
+// instead of relying solely on 503 retries and exponential backoff.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
 func createMonitoringService(ctx context.Context) (*monitoring.Service, error) {
 	googleClient, err := google.DefaultClient(ctx, monitoring.MonitoringReadScope)
 	if err != nil {
@@ -156,7 +223,10 @@ func createMonitoringService(ctx context.Context) (*monitoring.Service, error) {
 
 	googleClient.Timeout = *stackdriverHttpTimeout
 	googleClient.Transport = rehttp.NewTransport(
-		googleClient.Transport, // need to wrap DefaultClient transport
+		&rateLimitedTransport{
+			next:    googleClient.Transport, // need to wrap DefaultClient transport
+			limiter: rate.NewLimiter(rate.Limit(*stackdriverMaxQPS), 1),
+		},
 		rehttp.RetryAll(
 			rehttp.RetryMaxRetries(*stackdriverMaxRetries),
 			rehttp.RetryStatuses(*stackdriverRetryStatuses...)), // Cloud support suggests retrying on 503 errors
@@ -172,14 +242,35 @@ func createMonitoringService(ctx context.Context) (*monitoring.Service, error) {
 }
 
 type handler struct {
-	handler http.Handler
-	logger  log.Logger
-
-	projectIDs          []string
-	metricsPrefixes     []string
-	metricsExtraFilters []collectors.MetricFilter
-	additionalGatherer  prometheus.Gatherer
-	m                   *monitoring.Service
+	handler          http.Handler
+	defaultCollector *MultiProjectCollector
+	logger           *slog.Logger
+
+	projectIDs             []string
+	metricsPrefixes        []string
+	metricsPrefixesExclude []string
+	metricsExtraFilters    []collectors.MetricFilter
+	aggregation            collectors.Aggregation
+	aggregationOverrides   []collectors.MetricAggregation
+	additionalGatherer     prometheus.Gatherer
+	m                      *monitoring.Service
+
+	// boltDB is shared across all projects' delta stores when --monitoring.aggregate-deltas-store=bolt, since
+	// bbolt takes an OS-level exclusive lock on Open and a given project's store is still keyed by project
+	// within it, so project isolation survives sharing the one file handle. Nil in memory mode.
+	boltDB *bolt.DB
+
+	// deltaStores holds each project's DELTA counter/histogram stores, built once in newHandler and reused by
+	// every call to buildHandler. ServeHTTP calls buildHandler fresh on every ?collect=-filtered request, so
+	// building these inline there would reopen/rehydrate a store (and, in bolt mode, restart its TTL eviction
+	// goroutine) on every such scrape instead of accumulating state across scrapes as aggregate-deltas intends.
+	deltaStores map[string]projectDeltaStores
+}
+
+// projectDeltaStores bundles a single project's DELTA counter and histogram stores.
+type projectDeltaStores struct {
+	counterStore   delta.CounterStore
+	histogramStore delta.HistogramStore
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -190,49 +281,204 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(filters) > 0 {
-		h.innerHandler(filters).ServeHTTP(w, r)
+		handler, collector := h.buildHandler(filters)
+		collector.setContext(r.Context())
+		handler.ServeHTTP(w, r)
 		return
 	}
 
+	h.defaultCollector.setContext(r.Context())
 	h.handler.ServeHTTP(w, r)
 }
 
-func newHandler(projectIDs []string, metricPrefixes []string, metricExtraFilters []collectors.MetricFilter, m *monitoring.Service, logger log.Logger, additionalGatherer prometheus.Gatherer) *handler {
+func newHandler(projectIDs []string, metricPrefixes []string, metricPrefixesExclude []string, metricExtraFilters []collectors.MetricFilter, aggregation collectors.Aggregation, aggregationOverrides []collectors.MetricAggregation, m *monitoring.Service, logger *slog.Logger, additionalGatherer prometheus.Gatherer) *handler {
 	h := &handler{
-		logger:              logger,
-		projectIDs:          projectIDs,
-		metricsPrefixes:     metricPrefixes,
-		metricsExtraFilters: metricExtraFilters,
-		additionalGatherer:  additionalGatherer,
-		m:                   m,
+		logger:                 logger,
+		projectIDs:             projectIDs,
+		metricsPrefixes:        metricPrefixes,
+		metricsPrefixesExclude: metricPrefixesExclude,
+		metricsExtraFilters:    metricExtraFilters,
+		aggregation:            aggregation,
+		aggregationOverrides:   aggregationOverrides,
+		additionalGatherer:     additionalGatherer,
+		m:                      m,
+	}
+
+	if *monitoringAggregateDeltasStore == "bolt" {
+		db, err := bolt.Open(*monitoringAggregateDeltasStorePath, 0600, nil)
+		if err != nil {
+			logger.Error("failed to open bolt delta store", "path", *monitoringAggregateDeltasStorePath, "err", err)
+			os.Exit(1)
+		}
+		h.boltDB = db
+	}
+
+	h.deltaStores = make(map[string]projectDeltaStores, len(projectIDs))
+	for _, project := range projectIDs {
+		counterStore, histogramStore := h.createDeltaStores(project)
+		h.deltaStores[project] = projectDeltaStores{counterStore: counterStore, histogramStore: histogramStore}
 	}
 
-	h.handler = h.innerHandler(nil)
+	h.handler, h.defaultCollector = h.buildHandler(nil)
 	return h
 }
 
-func (h *handler) innerHandler(filters map[string]bool) http.Handler {
-	registry := prometheus.NewRegistry()
-
-	for _, project := range h.projectIDs {
-		monitoringCollector, err := collectors.NewMonitoringCollector(project, h.m, collectors.MonitoringCollectorOptions{
-			MetricTypePrefixes:        h.filterMetricTypePrefixes(filters),
-			ExtraFilters:              h.metricsExtraFilters,
-			RequestInterval:           *monitoringMetricsInterval,
-			RequestOffset:             *monitoringMetricsOffset,
-			IngestDelay:               *monitoringMetricsIngestDelay,
-			FillMissingLabels:         *collectorFillMissingLabels,
-			DropDelegatedProjects:     *monitoringDropDelegatedProjects,
-			AggregateDeltas:           *monitoringMetricsAggregateDeltas,
-			DescriptorCacheTTL:        *monitoringDescriptorCacheTTL,
-			DescriptorCacheOnlyGoogle: *monitoringDescriptorCacheOnlyGoogle,
-		}, h.logger, delta.NewInMemoryCounterStore(h.logger, *monitoringMetricsDeltasTTL), delta.NewInMemoryHistogramStore(h.logger, *monitoringMetricsDeltasTTL))
+// createDeltaStores builds the counter and histogram stores used to aggregate a single project's DELTA
+// metrics across scrapes, per --monitoring.aggregate-deltas-store. Each project gets its own store,
+// scoped to that project's key space, so two projects that happen to emit the same metric type and label
+// combination never merge into a single aggregated counter/histogram. In bolt mode every project's store
+// shares h.boltDB rather than opening its own file, since bbolt takes an OS-level exclusive lock on Open;
+// isolation instead comes from keying that shared handle by project. Called once per project from
+// newHandler; the result is cached in h.deltaStores and reused by every call to buildHandler.
+func (h *handler) createDeltaStores(project string) (delta.CounterStore, delta.HistogramStore) {
+	ttl := *monitoringMetricsDeltasTTL
+	if h.boltDB != nil {
+		counterStore, err := delta.NewBoltCounterStore(h.logger, ttl, h.boltDB, project)
+		if err != nil {
+			h.logger.Error("failed to initialize bolt counter store", "project", project, "err", err)
+			os.Exit(1)
+		}
+		histogramStore, err := delta.NewBoltHistogramStore(h.logger, ttl, h.boltDB, project)
 		if err != nil {
-			level.Error(h.logger).Log("err", err)
+			h.logger.Error("failed to initialize bolt histogram store", "project", project, "err", err)
 			os.Exit(1)
 		}
-		registry.MustRegister(monitoringCollector)
+		return counterStore, histogramStore
+	}
+
+	return delta.NewInMemoryCounterStore(h.logger, ttl), delta.NewInMemoryHistogramStore(h.logger, ttl)
+}
+
+// MultiProjectCollector fans the actual per-project collection (ListTimeSeries/ListMetricDescriptors calls
+// made from within each sub-collector's Collect) out over a bounded worker pool, so a scrape across dozens
+// of projects doesn't run them one at a time and blow past scrape_timeout. It honors the scrape deadline
+// carried by the context set via setContext for the in-flight request, abandoning any project collectors
+// that haven't started by the time that context is done.
+type MultiProjectCollector struct {
+	collectors    []prometheus.Collector
+	maxConcurrent int
+	logger        *slog.Logger
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// clampConcurrency floors n to 1, so a misconfigured --monitoring.max-concurrent-projects of 0 can't leave a
+// semaphore channel unbuffered (every collector goroutine would then block forever on its own send, deadlocking
+// the first scrape) and a negative value can't reach make(chan struct{}, n), which panics for a negative size.
+func clampConcurrency(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (c *MultiProjectCollector) setContext(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+func (c *MultiProjectCollector) getContext() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Describe intentionally sends no descriptors, so the registry treats this as an unchecked collector:
+// the per-project sub-collectors emit dynamically-labeled metrics that can't be declared up front.
+func (c *MultiProjectCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *MultiProjectCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := c.getContext()
+	sem := make(chan struct{}, clampConcurrency(c.maxConcurrent))
+	var wg sync.WaitGroup
+	for _, collector := range c.collectors {
+		select {
+		case <-ctx.Done():
+			c.logger.Warn("scrape deadline exceeded, abandoning remaining project collectors", "err", ctx.Err())
+			wg.Wait()
+			return
+		default:
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger.Warn("scrape deadline exceeded, abandoning remaining project collectors", "err", ctx.Err())
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(collector prometheus.Collector) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			collector.Collect(ch)
+		}(collector)
 	}
+	wg.Wait()
+}
+
+// buildHandler constructs the per-project monitoring collectors, wraps them in a MultiProjectCollector, and
+// returns the resulting scrape handler along with that collector so its context can be kept up to date with
+// the in-flight request's scrape deadline.
+func (h *handler) buildHandler(filters map[string]bool) (http.Handler, *MultiProjectCollector) {
+	// Build the per-project collectors over a bounded worker pool, so a deployment with many projects
+	// doesn't pay for descriptor discovery and collector construction one project at a time.
+	sem := make(chan struct{}, clampConcurrency(*monitoringMaxConcurrentProjects))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var projectCollectors []prometheus.Collector
+	for _, project := range h.projectIDs {
+		wg.Add(1)
+		go func(project string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			stores := h.deltaStores[project]
+			monitoringCollector, err := collectors.NewMonitoringCollector(project, h.m, collectors.MonitoringCollectorOptions{
+				MetricTypePrefixes:        h.filterMetricTypePrefixes(filters),
+				MetricTypePrefixesExclude: h.metricsPrefixesExclude,
+				ExtraFilters:              h.metricsExtraFilters,
+				RequestInterval:           *monitoringMetricsInterval,
+				RequestOffset:             *monitoringMetricsOffset,
+				IngestDelay:               *monitoringMetricsIngestDelay,
+				FillMissingLabels:         *collectorFillMissingLabels,
+				DropDelegatedProjects:     *monitoringDropDelegatedProjects,
+				AggregateDeltas:           *monitoringMetricsAggregateDeltas,
+				DescriptorCacheTTL:        *monitoringDescriptorCacheTTL,
+				DescriptorCacheOnlyGoogle: *monitoringDescriptorCacheOnlyGoogle,
+				MaxConcurrentPrefixes:     *monitoringMaxConcurrentPrefixes,
+				Aggregation:               h.aggregation,
+				AggregationOverrides:      h.aggregationOverrides,
+				NativeHistograms:          *monitoringNativeHistograms,
+			}, h.logger, stores.counterStore, stores.histogramStore)
+			if err != nil {
+				h.logger.Error("failed to create monitoring collector", "err", err)
+				os.Exit(1)
+			}
+
+			mu.Lock()
+			projectCollectors = append(projectCollectors, monitoringCollector)
+			mu.Unlock()
+		}(project)
+	}
+	wg.Wait()
+
+	multiProjectCollector := &MultiProjectCollector{
+		collectors:    projectCollectors,
+		maxConcurrent: clampConcurrency(*monitoringMaxConcurrentProjects),
+		logger:        h.logger,
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(multiProjectCollector)
+
 	var gatherers prometheus.Gatherer = registry
 	if h.additionalGatherer != nil {
 		gatherers = prometheus.Gatherers{
@@ -240,13 +486,20 @@ func (h *handler) innerHandler(filters map[string]bool) http.Handler {
 			registry,
 		}
 	}
-	opts := promhttp.HandlerOpts{ErrorLog: stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0)}
+	opts := promhttp.HandlerOpts{ErrorLog: errorStdLogger(h.logger)}
 	// Delegate http serving to Prometheus client library, which will call collector.Collect.
-	return promhttp.HandlerFor(gatherers, opts)
+	return promhttp.HandlerFor(gatherers, opts), multiProjectCollector
+}
+
+// errorStdLogger adapts logger to the *log.Logger that promhttp.HandlerOpts.ErrorLog expects, so scrape
+// errors go through the same slog handler (and thus the same format/output) as every other log line instead
+// of promhttp's default stderr logger.
+func errorStdLogger(logger *slog.Logger) *log.Logger {
+	return slog.NewLogLogger(logger.Handler(), slog.LevelError)
 }
 
 // filterMetricTypePrefixes filters the initial list of metric type prefixes, with the ones coming from an individual
-// prometheus collect request.
+// prometheus collect request, and drops any prefix matching h.metricsPrefixesExclude.
 func (h *handler) filterMetricTypePrefixes(filters map[string]bool) []string {
 	filteredPrefixes := h.metricsPrefixes
 	if len(filters) > 0 {
@@ -257,33 +510,56 @@ func (h *handler) filterMetricTypePrefixes(filters map[string]bool) []string {
 			}
 		}
 	}
-	return filteredPrefixes
+	return excludeMetricTypePrefixes(filteredPrefixes, h.metricsPrefixesExclude)
+}
+
+// excludeMetricTypePrefixes drops any prefix from prefixes that starts with one of the excludePrefixes. A prefix
+// broader than an exclude, such as `compute.googleapis.com/` with an exclude of `compute.googleapis.com/guest/`,
+// is kept as is; the excludes themselves are passed on to each collector as MetricTypePrefixesExclude.
+func excludeMetricTypePrefixes(prefixes []string, excludePrefixes []string) []string {
+	if len(excludePrefixes) == 0 {
+		return prefixes
+	}
+	var filtered []string
+	for _, prefix := range prefixes {
+		excluded := false
+		for _, excludePrefix := range excludePrefixes {
+			if strings.HasPrefix(prefix, excludePrefix) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, prefix)
+		}
+	}
+	return filtered
 }
 
 func main() {
-	promlogConfig := &promlog.Config{}
-	flag.AddFlags(kingpin.CommandLine, promlogConfig)
+	promslogConfig := &promslog.Config{}
+	flag.AddFlags(kingpin.CommandLine, promslogConfig)
 
 	kingpin.Version(version.Print("stackdriver_exporter"))
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 
-	logger := promlog.New(promlogConfig)
+	logger := promslog.New(promslogConfig)
 
 	ctx := context.Background()
 	if len(*projectIDs) == 0 && *projectsFilter == "" {
-		level.Info(logger).Log("msg", "Neither projectID nor projectsFilter was provided. Trying to discover it")
+		logger.Info("Neither projectID nor projectsFilter was provided. Trying to discover it")
 		var err error
 		projectIDs, err = getDefaultGCPProject(ctx)
 		if err != nil {
-			level.Error(logger).Log("msg", "no explicit projectID and error trying to discover default GCloud project", "err", err)
+			logger.Error("no explicit projectID and error trying to discover default GCloud project", "err", err)
 			os.Exit(1)
 		}
 	}
 
 	monitoringService, err := createMonitoringService(ctx)
 	if err != nil {
-		level.Error(logger).Log("msg", "failed to create monitoring service", "err", err)
+		logger.Error("failed to create monitoring service", "err", err)
 		os.Exit(1)
 	}
 
@@ -292,7 +568,7 @@ func main() {
 	if *projectsFilter != "" {
 		discoveredProjectIDs, err = utils.GetProjectIDsFromFilter(ctx, *projectsFilter)
 		if err != nil {
-			level.Error(logger).Log("msg", "failed to get project IDs from filter", "err", err)
+			logger.Error("failed to get project IDs from filter", "err", err)
 			os.Exit(1)
 		}
 	}
@@ -301,28 +577,32 @@ func main() {
 		discoveredProjectIDs = append(discoveredProjectIDs, *projectIDs...)
 	}
 
-	level.Info(logger).Log(
-		"msg", "Starting stackdriver_exporter",
+	logger.Info(
+		"Starting stackdriver_exporter",
 		"version", version.Info(),
 		"build_context", version.BuildContext(),
 		"projects", *projectIDs,
 		"metric_prefixes", *monitoringMetricsPrefixes,
+		"metric_prefixes_exclude", *monitoringMetricsPrefixesExclude,
 		"extra_filters", strings.Join(*monitoringMetricsExtraFilter, ","),
 		"projectIDs", fmt.Sprintf("%v", discoveredProjectIDs),
 		"projectsFilter", *projectsFilter,
 	)
 
 	parsedMetricsPrefixes := parseMetricTypePrefixes(*monitoringMetricsPrefixes)
+	parsedMetricsPrefixesExclude := parseMetricTypePrefixes(*monitoringMetricsPrefixesExclude)
 	metricExtraFilters := parseMetricExtraFilters()
+	aggregation := parseAggregation()
+	aggregationOverrides := parseAggregationOverrides(logger)
 
 	if *metricsPath == *stackdriverMetricsPath {
 		handler := newHandler(
-			discoveredProjectIDs, parsedMetricsPrefixes, metricExtraFilters, monitoringService, logger, prometheus.DefaultGatherer)
+			discoveredProjectIDs, parsedMetricsPrefixes, parsedMetricsPrefixesExclude, metricExtraFilters, aggregation, aggregationOverrides, monitoringService, logger, prometheus.DefaultGatherer)
 		http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler))
 	} else {
-		level.Info(logger).Log("msg", "Serving Stackdriver metrics at separate path", "path", *stackdriverMetricsPath)
+		logger.Info("Serving Stackdriver metrics at separate path", "path", *stackdriverMetricsPath)
 		handler := newHandler(
-			discoveredProjectIDs, parsedMetricsPrefixes, metricExtraFilters, monitoringService, logger, nil)
+			discoveredProjectIDs, parsedMetricsPrefixes, parsedMetricsPrefixesExclude, metricExtraFilters, aggregation, aggregationOverrides, monitoringService, logger, nil)
 		http.Handle(*stackdriverMetricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler))
 		http.Handle(*metricsPath, promhttp.Handler())
 	}
@@ -349,7 +629,7 @@ func main() {
 		}
 		landingPage, err := web.NewLandingPage(landingConfig)
 		if err != nil {
-			level.Error(logger).Log("err", err)
+			logger.Error("failed to create landing page", "err", err)
 			os.Exit(1)
 		}
 		http.Handle("/", landingPage)
@@ -357,7 +637,7 @@ func main() {
 
 	srv := &http.Server{}
 	if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
-		level.Error(logger).Log("msg", "Error starting server", "err", err)
+		logger.Error("Error starting server", "err", err)
 		os.Exit(1)
 	}
 }
@@ -400,3 +680,53 @@ func parseMetricExtraFilters() []collectors.MetricFilter {
 	}
 	return extraFilters
 }
+
+// parseAggregation builds the global Monitoring API ListTimeSeries aggregation settings from the
+// --monitoring.aggregation.* flags.
+func parseAggregation() collectors.Aggregation {
+	return collectors.Aggregation{
+		AlignmentPeriod:    *monitoringAggregationAlignmentPeriod,
+		PerSeriesAligner:   *monitoringAggregationPerSeriesAligner,
+		CrossSeriesReducer: *monitoringAggregationCrossSeriesReducer,
+		GroupByFields:      *monitoringAggregationGroupByFields,
+	}
+}
+
+// parseAggregationOverrides parses --monitoring.aggregation.per-prefix flags of the form
+// <prefix>:alignment-period=60s,per-series-aligner=ALIGN_RATE,cross-series-reducer=REDUCE_SUM,group-by-fields=a;b
+// into per-prefix overrides of the global aggregation settings. A malformed alignment-period is logged and
+// the exporter exits rather than falling back to a zero-value alignment period. Entries without a prefix and
+// unknown keys are skipped without a log line.
+func parseAggregationOverrides(logger *slog.Logger) []collectors.MetricAggregation {
+	var overrides []collectors.MetricAggregation
+	for _, po := range *monitoringAggregationPerPrefix {
+		targetedMetricPrefix, settings := utils.SplitExtraFilter(po, ":")
+		if targetedMetricPrefix == "" {
+			continue
+		}
+
+		override := collectors.MetricAggregation{
+			TargetedMetricPrefix: strings.ToLower(targetedMetricPrefix),
+		}
+		for _, kv := range strings.Split(settings, ",") {
+			key, value := utils.SplitExtraFilter(kv, "=")
+			switch key {
+			case "alignment-period":
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					logger.Error("invalid alignment-period in --monitoring.aggregation.per-prefix", "prefix", targetedMetricPrefix, "value", value, "err", err)
+					os.Exit(1)
+				}
+				override.Aggregation.AlignmentPeriod = d
+			case "per-series-aligner":
+				override.Aggregation.PerSeriesAligner = value
+			case "cross-series-reducer":
+				override.Aggregation.CrossSeriesReducer = value
+			case "group-by-fields":
+				override.Aggregation.GroupByFields = strings.Split(value, ";")
+			}
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides
+}
@@ -0,0 +1,361 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+func TestExcludeMetricTypePrefixes(t *testing.T) {
+	tests := []struct {
+		name            string
+		prefixes        []string
+		excludePrefixes []string
+		want            []string
+	}{
+		{
+			name:            "no excludes returns the input unchanged",
+			prefixes:        []string{"compute.googleapis.com/"},
+			excludePrefixes: nil,
+			want:            []string{"compute.googleapis.com/"},
+		},
+		{
+			name:            "exclude nested under a broader include leaves the include in place",
+			prefixes:        []string{"compute.googleapis.com/"},
+			excludePrefixes: []string{"compute.googleapis.com/guest/"},
+			want:            []string{"compute.googleapis.com/"},
+		},
+		{
+			name:            "exclude drops the only prefix nested under it",
+			prefixes:        []string{"compute.googleapis.com/guest/disk"},
+			excludePrefixes: []string{"compute.googleapis.com/guest/"},
+			want:            nil,
+		},
+		{
+			name:            "exclude drops any prefix it is a prefix of",
+			prefixes:        []string{"compute.googleapis.com/guest/disk", "compute.googleapis.com/instance/cpu"},
+			excludePrefixes: []string{"compute.googleapis.com/guest/"},
+			want:            []string{"compute.googleapis.com/instance/cpu"},
+		},
+		{
+			name:            "unrelated exclude leaves prefixes untouched",
+			prefixes:        []string{"pubsub.googleapis.com/subscription/"},
+			excludePrefixes: []string{"compute.googleapis.com/guest/"},
+			want:            []string{"pubsub.googleapis.com/subscription/"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := excludeMetricTypePrefixes(tt.prefixes, tt.excludePrefixes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("excludeMetricTypePrefixes(%v, %v) = %v, want %v", tt.prefixes, tt.excludePrefixes, got, tt.want)
+			}
+		})
+	}
+}
+
+type stubRoundTripper struct {
+	called bool
+	resp   *http.Response
+	err    error
+}
+
+func (s *stubRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.called = true
+	return s.resp, s.err
+}
+
+func TestRateLimitedTransportRoundTrip(t *testing.T) {
+	t.Run("forwards the request once the limiter allows it", func(t *testing.T) {
+		next := &stubRoundTripper{resp: &http.Response{StatusCode: http.StatusOK}}
+		transport := &rateLimitedTransport{next: next, limiter: rate.NewLimiter(rate.Inf, 1)}
+
+		resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+		if err != nil {
+			t.Fatalf("RoundTrip() error = %v", err)
+		}
+		if !next.called {
+			t.Error("RoundTrip() did not forward the request to the wrapped transport")
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("RoundTrip() returned status %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	})
+
+	t.Run("returns the limiter's error without forwarding once the request context is done", func(t *testing.T) {
+		next := &stubRoundTripper{}
+		transport := &rateLimitedTransport{next: next, limiter: rate.NewLimiter(0, 1)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil).WithContext(ctx)
+
+		if _, err := transport.RoundTrip(req); err == nil {
+			t.Fatal("RoundTrip() error = nil, want the limiter's context error")
+		}
+		if next.called {
+			t.Error("RoundTrip() forwarded the request despite the limiter never allowing it")
+		}
+	})
+}
+
+func TestClampConcurrency(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want int
+	}{
+		{"positive value is left unchanged", 4, 4},
+		{"zero is floored to 1", 0, 1},
+		{"negative value is floored to 1", -3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clampConcurrency(tt.n); got != tt.want {
+				t.Errorf("clampConcurrency(%d) = %d, want %d", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// trackingCollector records how many instances are inside Collect at once, so the test can assert the
+// MultiProjectCollector worker pool actually bounds concurrency rather than just bounding construction.
+type trackingCollector struct {
+	mu      *sync.Mutex
+	active  *int
+	maxSeen *int
+	release <-chan struct{}
+}
+
+func (c *trackingCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *trackingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	*c.active++
+	if *c.active > *c.maxSeen {
+		*c.maxSeen = *c.active
+	}
+	c.mu.Unlock()
+
+	<-c.release
+
+	c.mu.Lock()
+	*c.active--
+	c.mu.Unlock()
+}
+
+func TestMultiProjectCollectorCollectBoundsConcurrency(t *testing.T) {
+	const (
+		numCollectors = 5
+		maxConcurrent = 2
+	)
+	var mu sync.Mutex
+	active, maxSeen := 0, 0
+	release := make(chan struct{})
+
+	projectCollectors := make([]prometheus.Collector, numCollectors)
+	for i := range projectCollectors {
+		projectCollectors[i] = &trackingCollector{mu: &mu, active: &active, maxSeen: &maxSeen, release: release}
+	}
+
+	c := &MultiProjectCollector{
+		collectors:    projectCollectors,
+		maxConcurrent: maxConcurrent,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Collect(nil)
+		close(done)
+	}()
+
+	// Give the worker pool time to saturate before letting any collector finish.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxSeen > maxConcurrent {
+		t.Errorf("observed %d collectors running concurrently, want at most %d", maxSeen, maxConcurrent)
+	}
+}
+
+// signalingCollector closes signaled as soon as Collect is called, so the test can confirm the collector
+// actually ran rather than the pool deadlocking before reaching it.
+type signalingCollector struct {
+	signaled chan struct{}
+}
+
+func (c *signalingCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *signalingCollector) Collect(ch chan<- prometheus.Metric) {
+	close(c.signaled)
+}
+
+func TestMultiProjectCollectorCollectDoesNotDeadlockOnZeroConcurrency(t *testing.T) {
+	signaled := make(chan struct{})
+	c := &MultiProjectCollector{
+		collectors:    []prometheus.Collector{&signalingCollector{signaled: signaled}},
+		maxConcurrent: 0, // as if --monitoring.max-concurrent-projects=0 had been passed through unclamped
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Collect(nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Collect did not return: a zero maxConcurrent deadlocked the worker pool")
+	}
+
+	select {
+	case <-signaled:
+	default:
+		t.Error("Collect returned without ever calling the collector")
+	}
+}
+
+func TestErrorStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	errorStdLogger(logger).Print("scrape failed")
+
+	if !strings.Contains(buf.String(), "scrape failed") {
+		t.Errorf("errorStdLogger output = %q, want it to contain the logged message", buf.String())
+	}
+	if !strings.Contains(buf.String(), "level=ERROR") {
+		t.Errorf("errorStdLogger output = %q, want it logged through logger's handler at error level", buf.String())
+	}
+}
+
+// TestMonitoringNativeHistogramsFlagDefault pins --monitoring.native-histograms to default to false, so
+// upgrading stays a no-op for scrapers that haven't opted into native histograms: everything else that
+// wires *monitoringNativeHistograms through to collectors.MonitoringCollectorOptions.NativeHistograms lives
+// in buildHandler, which also talks to the live Monitoring API and so isn't exercised by this package's tests.
+func TestMonitoringNativeHistogramsFlagDefault(t *testing.T) {
+	if got := kingpin.CommandLine.GetFlag("monitoring.native-histograms").Model().Default; !reflect.DeepEqual(got, []string{"false"}) {
+		t.Errorf("monitoring.native-histograms default = %v, want [false] so upgrades keep emitting classic histograms by default", got)
+	}
+}
+
+// TestCreateDeltaStoresIsolatedPerProject guards the invariant h.deltaStores relies on: each project must get
+// its own store instance, so caching them by project in newHandler can't accidentally let two projects that
+// emit the same metric type and label combination share (and merge) aggregated DELTA state.
+func TestCreateDeltaStoresIsolatedPerProject(t *testing.T) {
+	*monitoringAggregateDeltasStore = "memory"
+	h := &handler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	counterA, histogramA := h.createDeltaStores("project-a")
+	counterB, histogramB := h.createDeltaStores("project-b")
+
+	if counterA == counterB {
+		t.Error("createDeltaStores returned the same counter store instance for two different projects")
+	}
+	if histogramA == histogramB {
+		t.Error("createDeltaStores returned the same histogram store instance for two different projects")
+	}
+}
+
+func TestParseAggregationOverrides(t *testing.T) {
+	discardLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name      string
+		perPrefix []string
+		want      []collectors.MetricAggregation
+	}{
+		{
+			name:      "no overrides",
+			perPrefix: nil,
+			want:      nil,
+		},
+		{
+			name: "full override is parsed and lowercases the prefix",
+			perPrefix: []string{
+				"Compute.googleapis.com/instance/disk:alignment-period=60s,per-series-aligner=ALIGN_RATE,cross-series-reducer=REDUCE_SUM,group-by-fields=resource.labels.instance_id;resource.labels.zone",
+			},
+			want: []collectors.MetricAggregation{
+				{
+					TargetedMetricPrefix: "compute.googleapis.com/instance/disk",
+					Aggregation: collectors.Aggregation{
+						AlignmentPeriod:    60 * time.Second,
+						PerSeriesAligner:   "ALIGN_RATE",
+						CrossSeriesReducer: "REDUCE_SUM",
+						GroupByFields:      []string{"resource.labels.instance_id", "resource.labels.zone"},
+					},
+				},
+			},
+		},
+		{
+			name: "entry without a prefix is skipped",
+			perPrefix: []string{
+				"alignment-period=60s",
+			},
+			want: nil,
+		},
+		{
+			name: "multiple prefixes each get their own override",
+			perPrefix: []string{
+				"compute.googleapis.com/:per-series-aligner=ALIGN_MEAN",
+				"pubsub.googleapis.com/:cross-series-reducer=REDUCE_MEAN",
+			},
+			want: []collectors.MetricAggregation{
+				{
+					TargetedMetricPrefix: "compute.googleapis.com/",
+					Aggregation:          collectors.Aggregation{PerSeriesAligner: "ALIGN_MEAN"},
+				},
+				{
+					TargetedMetricPrefix: "pubsub.googleapis.com/",
+					Aggregation:          collectors.Aggregation{CrossSeriesReducer: "REDUCE_MEAN"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*monitoringAggregationPerPrefix = tt.perPrefix
+			got := parseAggregationOverrides(discardLogger)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAggregationOverrides(%v) = %+v, want %+v", tt.perPrefix, got, tt.want)
+			}
+		})
+	}
+}